@@ -14,8 +14,23 @@ import (
 func main() {
 	port := flag.Int("p", 80, "port number")
 	directory := flag.String("d", "", "directory for uploaded files (default: create a temporary directory)")
+	metaBackend := flag.String("meta", "json", "metadata store used by the dir backend to persist Constraints: json or bbolt")
+	storageType := flag.String("storage", "dir", "storage backend to use: dir, mem or s3")
+	s3Bucket := flag.String("s3-bucket", "", "S3 bucket name (required for -storage=s3)")
+	s3Region := flag.String("s3-region", "us-east-1", "S3 region")
+	s3Endpoint := flag.String("s3-endpoint", "", "S3-compatible endpoint (leave blank for AWS S3 itself, set for MinIO and similar)")
+	s3AccessKey := flag.String("s3-access-key", "", "S3 access key (leave blank to use the default credential chain)")
+	s3SecretKey := flag.String("s3-secret-key", "", "S3 secret key")
+	s3Prefix := flag.String("s3-prefix", "", "key prefix for objects stored in S3")
+	s3MetaPath := flag.String("s3-meta-path", "", "path to a local bbolt database persisting Constraints for the s3 backend across restarts (leave blank to keep them in memory only)")
+	filePasswordSalt := flag.String("file-password-salt", "", "server-wide salt mixed into per-file passwords before hashing (keep it stable across restarts)")
+	shred := flag.Bool("shred", false, "overwrite a file's bytes before deleting it when its Constraints.Delete removal fires (dir backend only)")
+	clamav := flag.String("clamav", "", "address of a clamd daemon to scan uploads with (e.g. 127.0.0.1:3310); blank disables scanning")
+	clamavFailOpen := flag.Bool("clamav-fail-open", false, "allow an upload through if clamd is unreachable or errors out, instead of rejecting it")
 	flag.Parse()
 
+	storage.SetPasswordSalt([]byte(*filePasswordSalt))
+
 	password, err := gopass.GetPass("Please enter a password for file upload: ")
 	if err != nil {
 		log.Fatalln("Error:", err)
@@ -33,12 +48,34 @@ func main() {
 		log.Fatalf("Caught signal %v, exiting...\n", sig)
 	}()
 
-	st, err := storage.NewDirectory(*directory)
+	var st storage.Storage
+	switch *storageType {
+	case "dir":
+		st, err = storage.NewDirectory(*directory, *metaBackend, *shred)
+	case "mem":
+		st = storage.NewMemory()
+	case "s3":
+		st, err = storage.NewS3(storage.S3Config{
+			Bucket:    *s3Bucket,
+			Region:    *s3Region,
+			Endpoint:  *s3Endpoint,
+			AccessKey: *s3AccessKey,
+			SecretKey: *s3SecretKey,
+			Prefix:    *s3Prefix,
+			MetaPath:  *s3MetaPath,
+		})
+	default:
+		log.Fatalln("Unknown storage backend:", *storageType)
+	}
 	if err != nil {
 		log.Fatalln("Could not create storage:", err)
 	}
 	log.Printf("Starting go-share on port %d.\n%v\n", *port, st)
-	if err := share.New(st, password).Start(*port); err != nil {
+	server := share.New(st, password)
+	if *clamav != "" {
+		server.SetClamAV(*clamav, *clamavFailOpen)
+	}
+	if err := server.Start(*port); err != nil {
 		log.Fatalln("Could not start server:", err)
 	}
 }
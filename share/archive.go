@@ -0,0 +1,127 @@
+package share
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mabu/go-share/share/storage"
+)
+
+// handleArchive streams a synthetic zip or tar.gz archive of the files named
+// by repeated "f" query arguments, e.g. GET /archive.zip?f=a&f=b. If any
+// named file is inaccessible, the whole archive is refused rather than
+// silently served incomplete.
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request, format string) {
+	names := r.URL.Query()["f"]
+	if len(names) == 0 {
+		http.Error(w, "No files specified.", http.StatusBadRequest)
+		return
+	}
+	// Check that every file is currently accessible before opening (and so
+	// consuming download counts) any of them: if a later name is refused,
+	// the whole archive is refused, and earlier files must not have already
+	// paid for bytes that are never delivered.
+	for _, name := range names {
+		file, _, _, err := s.storage.Stat(name)
+		if err != nil {
+			log.Printf("Could not include %s in archive: %s\n", name, err)
+			http.Error(w, fmt.Sprintf("Cannot include %s in the archive: %s", name, err), http.StatusBadRequest)
+			return
+		}
+		file.Close()
+	}
+	type archiveFile struct {
+		name string
+		file io.ReadCloser
+		size int64
+		c    *storage.Constraints
+	}
+	files := make([]archiveFile, 0, len(names))
+	defer func() {
+		for _, f := range files {
+			f.file.Close()
+		}
+	}()
+	for _, name := range names {
+		file, size, c, err := s.storage.Open(name)
+		if err != nil {
+			log.Printf("Could not include %s in archive: %s\n", name, err)
+			// The Stat pass above can't fully rule out a concurrent request
+			// racing us between it and this Open: refund the download counts
+			// already consumed by files opened earlier in this loop, since
+			// none of their bytes will be delivered after all.
+			for _, f := range files {
+				// Refund before Close: Close triggers the deferred removal
+				// for a file whose last download this was, and that removal
+				// only backs off once Downloads no longer reads -1.
+				refundDownload(f.c)
+				f.file.Close()
+			}
+			files = nil
+			http.Error(w, fmt.Sprintf("Cannot include %s in the archive: %s", name, err), http.StatusBadRequest)
+			return
+		}
+		files = append(files, archiveFile{name: name, file: file, size: size, c: c})
+	}
+	filename := fmt.Sprintf("go-share-%d.%s", time.Now().Unix(), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	switch format {
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		zw := zip.NewWriter(w)
+		for _, f := range files {
+			fw, err := zw.Create(f.name)
+			if err != nil {
+				log.Printf("Could not add %s to zip archive: %s\n", f.name, err)
+				continue
+			}
+			if _, err := io.Copy(fw, f.file); err != nil {
+				log.Printf("Error streaming %s into zip archive: %s\n", f.name, err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			log.Println("Error finalizing zip archive:", err)
+		}
+	case "tar.gz":
+		w.Header().Set("Content-Type", "application/gzip")
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+		for _, f := range files {
+			if err := tw.WriteHeader(&tar.Header{Name: f.name, Size: f.size, Mode: 0644}); err != nil {
+				log.Printf("Could not add %s to tar archive: %s\n", f.name, err)
+				continue
+			}
+			if _, err := io.Copy(tw, f.file); err != nil {
+				log.Printf("Error streaming %s into tar archive: %s\n", f.name, err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			log.Println("Error finalizing tar archive:", err)
+		}
+		if err := gw.Close(); err != nil {
+			log.Println("Error finalizing gzip stream:", err)
+		}
+	}
+}
+
+// refundDownload undoes the exact Downloads accounting Open applies, for a
+// file whose bytes turned out not to be deliverable after all: -1 (the last
+// download, about to be removed) goes back to 1, and a plain decrement goes
+// back up by one. An unlimited (0) count is untouched, matching Open's own
+// no-op for it.
+func refundDownload(c *storage.Constraints) {
+	c.Lock()
+	defer c.Unlock()
+	switch {
+	case c.Downloads == -1:
+		c.Downloads = 1
+	case c.Downloads > 0:
+		c.Downloads++
+	}
+}
@@ -0,0 +1,107 @@
+package share
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/mabu/go-share/share/storage"
+)
+
+// SetClamAV configures s to scan every upload through a clamd daemon
+// listening at addr (e.g. "127.0.0.1:3310") using the INSTREAM protocol.
+// If clamd is unreachable or errors out, the upload is allowed through when
+// failOpen is true and rejected otherwise.
+func (s *Server) SetClamAV(addr string, failOpen bool) {
+	s.clamdAddr = addr
+	s.clamdFailOpen = failOpen
+}
+
+// addScanned stores file under name with constraints c, tee-ing it to clamd
+// as it streams into storage rather than buffering the whole upload in
+// memory. If the scan finds a virus, the bytes already written to storage
+// are removed and infected is reported true. If clamd is configured,
+// addScanned is the only path that should reach storage.Add for an upload.
+func (s *Server) addScanned(file io.Reader, name string, c storage.Constraints) (infected bool, signature string, err error) {
+	if s.clamdAddr == "" {
+		return false, "", s.storage.Add(file, name, c)
+	}
+	pr, pw := io.Pipe()
+	scanDone := make(chan struct{})
+	go func() {
+		defer close(scanDone)
+		inf, sig, serr := clamdScan(s.clamdAddr, pr)
+		if serr != nil {
+			log.Println("ClamAV scan error:", serr)
+			infected = !s.clamdFailOpen
+			signature = "scan unavailable"
+			// Drain whatever storage.Add still has left to tee through, so
+			// it doesn't block writing into a pipe nobody is reading.
+			io.Copy(ioutil.Discard, pr)
+			pr.Close()
+			return
+		}
+		infected, signature = inf, sig
+		pr.Close()
+	}()
+	err = s.storage.Add(io.TeeReader(file, pw), name, c)
+	pw.Close()
+	<-scanDone
+	if err != nil {
+		return false, "", err
+	}
+	if infected {
+		s.storage.Remove(name)
+	}
+	return infected, signature, nil
+}
+
+// clamdScan streams r to clamd's INSTREAM command and reports whether it
+// found a virus, and its signature name if so.
+func clamdScan(addr string, r io.Reader) (infected bool, signature string, err error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return false, "", fmt.Errorf("error connecting to clamd at %s: %v", addr, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("zINSTREAM\000")); err != nil {
+		return false, "", fmt.Errorf("error starting INSTREAM: %v", err)
+	}
+	buf := make([]byte, 8192)
+	var size [4]byte
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, werr := conn.Write(size[:]); werr != nil {
+				return false, "", fmt.Errorf("error writing to clamd: %v", werr)
+			}
+			if _, werr := conn.Write(buf[:n]); werr != nil {
+				return false, "", fmt.Errorf("error writing to clamd: %v", werr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, "", fmt.Errorf("error reading upload for scanning: %v", err)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil { // zero-length chunk ends the stream
+		return false, "", fmt.Errorf("error ending INSTREAM: %v", err)
+	}
+	resp, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return false, "", fmt.Errorf("error reading clamd response: %v", err)
+	}
+	reply := strings.TrimRight(string(resp), "\x00\r\n")
+	if strings.HasSuffix(reply, "FOUND") {
+		sig := strings.TrimSuffix(strings.TrimPrefix(reply, "stream:"), "FOUND")
+		return true, strings.TrimSpace(sig), nil
+	}
+	return false, "", nil
+}
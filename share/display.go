@@ -0,0 +1,130 @@
+package share
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/quick"
+	"github.com/dustin/go-humanize"
+	"github.com/russross/blackfriday/v2"
+)
+
+// maxPreviewSize is the largest file read into memory for an inline preview
+// on the /d/ display page, to avoid pathological cost for huge files. Larger
+// files get no text preview, but are still hashed in full.
+const maxPreviewSize = 512 * 1024
+
+// displayData is the data passed to tmplDisplay.
+type displayData struct {
+	Name      string
+	Size      string
+	MIMEType  string
+	Expire    string
+	Downloads string
+	Hash      string
+	Preview   template.HTML
+}
+
+// handleDisplay renders an HTML page with metadata and an inline preview for
+// name at /d/<name>. Raw bytes are still served, unpreviewed, at /<name>.
+func (s *Server) handleDisplay(w http.ResponseWriter, r *http.Request, name string) {
+	file, size, constraints, err := s.storage.Stat(name)
+	if err != nil {
+		log.Printf("Could not open %s for display: %s\n", name, err)
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	var buf []byte
+	if size <= maxPreviewSize {
+		if buf, err = ioutil.ReadAll(io.TeeReader(file, h)); err != nil {
+			log.Printf("Error reading %s for display: %s\n", name, err)
+		}
+	} else if _, err := io.Copy(h, file); err != nil {
+		log.Printf("Error hashing %s for display: %s\n", name, err)
+	}
+
+	mimeType := constraints.MIMEType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	executeTemplate(tmplDisplay, w, displayData{
+		Name:      name,
+		Size:      humanize.Bytes(uint64(size)),
+		MIMEType:  mimeType,
+		Expire:    humanizeExpire(constraints.Expire),
+		Downloads: humanizeDownloads(constraints.Downloads),
+		Hash:      fmt.Sprintf("%x", h.Sum(nil)),
+		Preview:   renderPreview(name, mimeType, buf),
+	})
+}
+
+func humanizeExpire(expire time.Time) string {
+	if expire.IsZero() {
+		return "Never"
+	}
+	return humanize.Time(expire)
+}
+
+func humanizeDownloads(downloads int) string {
+	switch {
+	case downloads == 0:
+		return "Unlimited"
+	case downloads == -1:
+		return "0 (limit reached)"
+	default:
+		return fmt.Sprint(downloads)
+	}
+}
+
+// renderPreview builds the inline preview shown on the display page. src
+// points back at the raw download route, so previews load exactly the bytes
+// a plain download would.
+func renderPreview(name, mimeType string, buf []byte) template.HTML {
+	src := template.HTMLEscapeString("/" + url.QueryEscape(name))
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return template.HTML(fmt.Sprintf(`<img src="%s" alt="%s" style="max-width:100%%;" />`, src, template.HTMLEscapeString(name)))
+	case strings.HasPrefix(mimeType, "audio/"):
+		return template.HTML(fmt.Sprintf(`<audio controls="controls" src="%s"></audio>`, src))
+	case strings.HasPrefix(mimeType, "video/"):
+		return template.HTML(fmt.Sprintf(`<video controls="controls" src="%s" style="max-width:100%%;"></video>`, src))
+	case mimeType == "application/pdf":
+		return template.HTML(fmt.Sprintf(`<embed src="%s" type="application/pdf" width="100%%" height="800" />`, src))
+	case buf == nil:
+		return template.HTML("<p>No preview available.</p>")
+	case strings.HasSuffix(strings.ToLower(name), ".md") || strings.HasSuffix(strings.ToLower(name), ".markdown"):
+		return template.HTML(blackfriday.Run(buf))
+	case strings.HasPrefix(mimeType, "text/") || mimeType == "application/json":
+		return highlightCode(name, buf)
+	default:
+		return template.HTML("<p>No preview available.</p>")
+	}
+}
+
+// highlightCode renders buf as syntax-highlighted HTML, picking a lexer from
+// name's extension, falling back to a plain <pre> block.
+func highlightCode(name string, buf []byte) template.HTML {
+	lexerName := "plaintext"
+	if l := lexers.Match(name); l != nil {
+		lexerName = l.Config().Name
+	}
+	var out bytes.Buffer
+	if err := quick.Highlight(&out, string(buf), lexerName, "html", "github"); err != nil {
+		return template.HTML("<pre>" + template.HTMLEscapeString(string(buf)) + "</pre>")
+	}
+	return template.HTML(out.String())
+}
@@ -0,0 +1,25 @@
+package share
+
+import "html/template"
+
+var tmplDisplay = template.Must(template.New("display").Parse(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="en" lang="en">
+<head>
+    <meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
+    <title>{{.Name}} - go-share</title>
+</head>
+<body>
+	<table>
+		<tr><td>Name:</td><td>{{.Name}}</td></tr>
+		<tr><td>Size:</td><td>{{.Size}}</td></tr>
+		<tr><td>Type:</td><td>{{.MIMEType}}</td></tr>
+		<tr><td>Expires:</td><td>{{.Expire}}</td></tr>
+		<tr><td>Downloads left:</td><td>{{.Downloads}}</td></tr>
+		<tr><td>SHA-256:</td><td>{{.Hash}}</td></tr>
+	</table>
+	<p><a href="/{{.Name}}">Download</a></p>
+	<div id="preview">
+		{{.Preview}}
+	</div>
+</body>
+</html>`))
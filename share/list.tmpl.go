@@ -29,7 +29,7 @@ var tmplList = template.Must(template.New("list").Funcs(template.FuncMap{"defaul
 <body>
 	<p>
 		{{range .}}
-		<a href="{{.}}">{{.}}</a><br />
+		<a href="{{.}}">{{.}}</a> (<a href="/d/{{.}}">view</a>)<br />
 		{{end}}
 	</p>
     <form action="" method="post" enctype="multipart/form-data">
@@ -63,6 +63,13 @@ var tmplList = template.Must(template.New("list").Funcs(template.FuncMap{"defaul
 					(blank means unlimited)
 				</td>
 			</tr>
+			<tr>
+				<td class="left">File password:</td>
+				<td class="right">
+					<input type="password" name="file_password" />
+					(blank means no per-file password)
+				</td>
+			</tr>
 			<tr>
 				<td class="left">Public:</td>
 				<td class="right">
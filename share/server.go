@@ -4,11 +4,16 @@ package share
 import (
 	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mabu/go-share/share/storage"
@@ -16,8 +21,10 @@ import (
 
 // Server is a file sharing server.
 type Server struct {
-	passwordHash []byte
-	storage      storage.Storage
+	passwordHash  []byte
+	storage       storage.Storage
+	clamdAddr     string
+	clamdFailOpen bool
 }
 
 // New creates a new server which stores uploaded files in s.
@@ -36,13 +43,26 @@ func (s *Server) Start(port int) error {
 
 // ServerHTTP handles HTTP requests.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/" {
+	switch r.URL.Path {
+	case "/":
 		if r.FormValue("upload") != "" {
 			executeTemplate(tmplMessage, w, s.handleAdd(r))
 		} else {
 			executeTemplate(tmplList, w, s.storage.List())
 		}
-	} else {
+	case "/upload.json":
+		s.handleUploadJSON(w, r)
+	case "/files.json":
+		writeJSON(w, s.storage.List())
+	case "/archive.zip":
+		s.handleArchive(w, r, "zip")
+	case "/archive.tar.gz":
+		s.handleArchive(w, r, "tar.gz")
+	default:
+		if strings.HasPrefix(r.URL.Path, "/d/") {
+			s.handleDisplay(w, r, r.URL.Path[len("/d/"):])
+			return
+		}
 		name := r.URL.Path[1:]
 		if err := s.storage.Serve(w, r, name); err != nil {
 			log.Printf("Could not serve %s: %s\n", name, err)
@@ -51,6 +71,13 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error encoding JSON response:", err)
+	}
+}
+
 func executeTemplate(t *template.Template, w http.ResponseWriter, data interface{}) {
 	if err := t.Execute(w, data); err != nil {
 		log.Printf("Error executing template %s: %s", t.Name(), err)
@@ -75,35 +102,153 @@ func (s *Server) handleAdd(r *http.Request) string {
 		log.Println("Error: no file name.")
 		return "Error: no file name."
 	}
+	c, err := parseConstraints(r)
+	if err != nil {
+		log.Println("Error parsing constraints:", err)
+		return "Error: " + err.Error() + "."
+	}
+	var reader io.Reader
+	reader, c.MIMEType = detectMIME(file)
+	infected, signature, err := s.addScanned(reader, name, c)
+	if err != nil {
+		log.Println("Could not add the file:", err)
+		return "Error."
+	}
+	if infected {
+		log.Println("Rejected", name, "- virus detected:", signature)
+		return "Virus detected: " + signature
+	}
+	link := "http://" + r.Host + "/" + url.QueryEscape(name)
+	if c.Token != "" {
+		link += "?t=" + url.QueryEscape(c.Token)
+	}
+	return "Direct link: " + link
+}
+
+// parseConstraints builds Constraints from the upload form fields shared by
+// the HTML form (handleAdd) and the JSON API (handleUploadJSON).
+func parseConstraints(r *http.Request) (storage.Constraints, error) {
 	c := storage.Constraints{
 		Public: r.FormValue("public") != "",
 		Delete: r.FormValue("delete") != "",
 	}
+	if fp := r.FormValue("file_password"); fp != "" {
+		passwordHash, err := storage.HashPassword(fp)
+		if err != nil {
+			return c, fmt.Errorf("error hashing file password: %v", err)
+		}
+		token, err := storage.GenerateToken()
+		if err != nil {
+			return c, fmt.Errorf("error generating token: %v", err)
+		}
+		c.PasswordHash = passwordHash
+		c.Token = token
+	}
 	if t := r.FormValue("expire"); t != "" {
 		utc, err := time.Parse("2006-01-02 15:04:05", t)
 		if err != nil {
-			log.Println("Error parsing expire:", err)
-			return "Error: invalid expiration date."
+			return c, fmt.Errorf("invalid expiration date: %v", err)
 		}
 		y, m, d := utc.Date()
 		H, M, S := utc.Clock()
 		c.Expire = time.Date(y, m, d, H, M, S, utc.Nanosecond(), time.Local)
 	}
 	if d := r.FormValue("downloads"); d != "" {
-		if c.Downloads, err = strconv.Atoi(d); err != nil {
-			log.Println("Error parsing downloads:", err)
-			return "Error: invalid number of downloads."
-		} else if c.Downloads < 1 {
-			log.Println("Invalid number of downloads:", c.Downloads)
-			return "Error: number of downloads should be positive."
+		downloads, err := strconv.Atoi(d)
+		if err != nil {
+			return c, fmt.Errorf("invalid number of downloads: %v", err)
+		} else if downloads < 1 {
+			return c, fmt.Errorf("number of downloads should be positive")
 		}
+		c.Downloads = downloads
+	}
+	return c, nil
+}
+
+// pomfFile describes one uploaded file in the Pomf-compatible JSON response.
+type pomfFile struct {
+	URL  string `json:"url"`
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// pomfResponse is the Pomf-compatible JSON response of /upload.json.
+type pomfResponse struct {
+	Success     bool       `json:"success"`
+	Files       []pomfFile `json:"files,omitempty"`
+	ErrorCode   int        `json:"errorcode,omitempty"`
+	Description string     `json:"description,omitempty"`
+}
+
+// handleUploadJSON implements the Pomf upload API: POST multipart files
+// under the "files[]" field and get back a pomfResponse.
+func (s *Server) handleUploadJSON(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.uploadJSON(r))
+}
+
+func (s *Server) uploadJSON(r *http.Request) pomfResponse {
+	if !bytes.Equal(hash(r.FormValue("password")), s.passwordHash) {
+		log.Println("Wrong password.")
+		return pomfResponse{ErrorCode: http.StatusUnauthorized, Description: "Wrong password."}
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		log.Println("Error parsing multipart form:", err)
+		return pomfResponse{ErrorCode: http.StatusBadRequest, Description: "Error parsing upload."}
 	}
-	err = s.storage.Add(file, name, c)
+	headers := r.MultipartForm.File["files[]"]
+	if len(headers) == 0 {
+		headers = r.MultipartForm.File["file"]
+	}
+	if len(headers) == 0 {
+		return pomfResponse{ErrorCode: http.StatusBadRequest, Description: "No files provided."}
+	}
+	c, err := parseConstraints(r)
 	if err != nil {
-		log.Println("Could not add the file:", err)
-		return "Error."
+		log.Println("Error parsing constraints:", err)
+		return pomfResponse{ErrorCode: http.StatusBadRequest, Description: err.Error()}
 	}
-	return "Direct link: http://" + r.Host + "/" + url.QueryEscape(name)
+	files := make([]pomfFile, 0, len(headers))
+	for _, header := range headers {
+		f, err := header.Open()
+		if err != nil {
+			log.Println("Error opening upload:", err)
+			return pomfResponse{ErrorCode: http.StatusInternalServerError, Description: "Error reading " + header.Filename + "."}
+		}
+		name := header.Filename
+		fc := c
+		if len(c.PasswordHash) > 0 {
+			if fc.Token, err = storage.GenerateToken(); err != nil {
+				f.Close()
+				log.Println("Error generating token:", err)
+				return pomfResponse{ErrorCode: http.StatusInternalServerError, Description: "Error protecting " + name + "."}
+			}
+		}
+		reader, mimeType := detectMIME(f)
+		fc.MIMEType = mimeType
+		h := sha256.New()
+		infected, signature, err := s.addScanned(io.TeeReader(reader, h), name, fc)
+		f.Close()
+		if err != nil {
+			log.Println("Could not add the file:", err)
+			return pomfResponse{ErrorCode: http.StatusInternalServerError, Description: "Error storing " + name + "."}
+		}
+		if infected {
+			log.Println("Rejected", name, "- virus detected:", signature)
+			return pomfResponse{ErrorCode: http.StatusBadRequest, Description: "Virus detected in " + name + ": " + signature}
+		}
+		fileURL := "http://" + r.Host + "/" + url.QueryEscape(name)
+		if fc.Token != "" {
+			fileURL += "?t=" + url.QueryEscape(fc.Token)
+		}
+		files = append(files, pomfFile{
+			URL:  fileURL,
+			Name: name,
+			Hash: fmt.Sprintf("%x", h.Sum(nil)),
+			Size: header.Size,
+		})
+	}
+	return pomfResponse{Success: true, Files: files}
 }
 
 func hash(password string) []byte {
@@ -111,3 +256,13 @@ func hash(password string) []byte {
 	h.Write([]byte(password))
 	return h.Sum(nil)
 }
+
+// detectMIME sniffs the content type from the first 512 bytes of r, as
+// recommended by http.DetectContentType, and returns a reader which still
+// yields those bytes to its caller.
+func detectMIME(r io.Reader) (io.Reader, string) {
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(r, buf)
+	buf = buf[:n]
+	return io.MultiReader(bytes.NewReader(buf), r), http.DetectContentType(buf)
+}
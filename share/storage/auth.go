@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordSalt is mixed into every per-file password before it is hashed
+// with bcrypt. It should be set once, via SetPasswordSalt, before any file
+// password is hashed or checked; changing it invalidates every previously
+// hashed password.
+var PasswordSalt []byte
+
+// SetPasswordSalt configures the server-wide salt used for per-file
+// passwords. It is not a replacement for bcrypt's own per-hash salt: it is a
+// server-wide secret ("pepper") so that a leaked metadata store alone is not
+// enough to brute-force file passwords offline.
+func SetPasswordSalt(salt []byte) {
+	PasswordSalt = salt
+}
+
+// HashPassword hashes a per-file password for storage in Constraints.PasswordHash.
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword(append([]byte(password), PasswordSalt...), bcrypt.DefaultCost)
+}
+
+func checkPassword(hash []byte, password string) bool {
+	return bcrypt.CompareHashAndPassword(hash, append([]byte(password), PasswordSalt...)) == nil
+}
+
+// GenerateToken returns a random ~96-byte URL-safe token that grants access
+// to a password-protected file without re-entering the password.
+func GenerateToken() (string, error) {
+	b := make([]byte, 96)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// cookieName derives a valid cookie token from a file path such as
+// r.URL.Path: a raw path contains "/", which http.Cookie.String rejects,
+// silently dropping the Set-Cookie header if used directly.
+func cookieName(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return "t_" + hex.EncodeToString(sum[:])
+}
+
+// authorized reports whether r already carries proof of access to a
+// password-protected file, either via the ?t= query argument or a
+// previously issued cookie.
+func authorized(r *http.Request, c *Constraints) bool {
+	if len(c.PasswordHash) == 0 {
+		return true
+	}
+	if t := r.URL.Query().Get("t"); t != "" && t == c.Token {
+		return true
+	}
+	if cookie, err := r.Cookie(cookieName(r.URL.Path)); err == nil && cookie.Value == c.Token {
+		return true
+	}
+	return false
+}
+
+// challenge handles a request to a password-protected file which did not
+// already prove access: it renders a login form on GET, and on POST checks
+// the submitted password, setting a short-lived cookie and redirecting back
+// on success. It always writes a complete response to w.
+func challenge(w http.ResponseWriter, r *http.Request, name string, c *Constraints) {
+	if r.Method == http.MethodPost {
+		if checkPassword(c.PasswordHash, r.FormValue("password")) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     cookieName(r.URL.Path),
+				Value:    c.Token,
+				Path:     r.URL.Path,
+				Expires:  time.Now().Add(24 * time.Hour),
+				HttpOnly: true,
+			})
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
+		renderLoginForm(w, name, true)
+		return
+	}
+	renderLoginForm(w, name, false)
+}
+
+func renderLoginForm(w http.ResponseWriter, name string, wrong bool) {
+	w.WriteHeader(http.StatusUnauthorized)
+	msg := ""
+	if wrong {
+		msg = "<p>Wrong password.</p>"
+	}
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html><head><title>%s - password required</title></head>
+<body>
+%s
+<form method="post">
+	<p>This file is password-protected.</p>
+	<input type="password" name="password" autofocus="autofocus" />
+	<input type="submit" value="View" />
+</form>
+</body></html>`, name, msg)
+}
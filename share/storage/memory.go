@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memFile holds the bytes and constraints for a single file kept in memory.
+type memFile struct {
+	data        []byte
+	constraints *Constraints
+}
+
+// memory is a Storage which keeps everything in process memory. It is meant
+// for tests and quick local runs, not for production use: nothing survives
+// a restart.
+type memory struct {
+	sync.RWMutex
+	files map[string]*memFile
+}
+
+// NewMemory returns a Storage which keeps uploaded files in memory.
+func NewMemory() Storage {
+	return &memory{files: make(map[string]*memFile)}
+}
+
+func (m *memory) Add(file io.Reader, name string, c Constraints) error {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.files[name] = &memFile{data: data, constraints: &c}
+	if !c.Expire.IsZero() {
+		time.AfterFunc(c.Expire.Sub(time.Now()), func() { m.remove(name) })
+	}
+	return nil
+}
+
+func (m *memory) remove(name string) {
+	m.Lock()
+	defer m.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		return
+	}
+	f.constraints.Lock()
+	defer f.constraints.Unlock()
+	if f.constraints.Downloads != -1 && !f.constraints.expired() {
+		return
+	}
+	delete(m.files, name)
+}
+
+func (m *memory) Remove(name string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.files, name)
+}
+
+func (m *memory) List() []string {
+	m.RLock()
+	defer m.RUnlock()
+	l := make([]string, 0, len(m.files))
+	for name, f := range m.files {
+		if f.constraints.Public {
+			l = append(l, name)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
+
+func (m *memory) Serve(w http.ResponseWriter, r *http.Request, name string) error {
+	if strings.Contains(name, "/") || name == "" {
+		return errors.New("invalid file name")
+	}
+	m.RLock()
+	f, ok := m.files[name]
+	m.RUnlock()
+	if !ok {
+		return errors.New("file not stored")
+	}
+	f.constraints.RLock()
+	if f.constraints.expired() {
+		f.constraints.RUnlock()
+		return errors.New("file recently expired")
+	}
+	if !authorized(r, f.constraints) {
+		f.constraints.RUnlock()
+		challenge(w, r, name, f.constraints)
+		return nil
+	}
+	if f.constraints.Downloads > 0 {
+		f.constraints.RUnlock()
+		f.constraints.Lock()
+		switch f.constraints.Downloads {
+		case 1:
+			f.constraints.Downloads = -1
+			defer m.remove(name)
+		case -1:
+			f.constraints.Unlock()
+			return errors.New("download limit exceeded")
+		default:
+			f.constraints.Downloads--
+		}
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(f.data))
+		f.constraints.Unlock()
+		return nil
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(f.data))
+	f.constraints.RUnlock()
+	return nil
+}
+
+func (m *memory) Open(name string) (io.ReadCloser, int64, *Constraints, error) {
+	if strings.Contains(name, "/") || name == "" {
+		return nil, 0, nil, errors.New("invalid file name")
+	}
+	m.RLock()
+	f, ok := m.files[name]
+	m.RUnlock()
+	if !ok {
+		return nil, 0, nil, errors.New("file not stored")
+	}
+	f.constraints.Lock()
+	if f.constraints.expired() {
+		f.constraints.Unlock()
+		return nil, 0, nil, errors.New("file recently expired")
+	}
+	if len(f.constraints.PasswordHash) > 0 {
+		f.constraints.Unlock()
+		return nil, 0, nil, errors.New("file is password protected")
+	}
+	remove := false
+	if f.constraints.Downloads > 0 {
+		switch f.constraints.Downloads {
+		case 1:
+			f.constraints.Downloads = -1
+			remove = true
+		case -1:
+			f.constraints.Unlock()
+			return nil, 0, nil, errors.New("download limit exceeded")
+		default:
+			f.constraints.Downloads--
+		}
+	}
+	data := f.data
+	constraints := f.constraints
+	f.constraints.Unlock()
+	if remove {
+		m.remove(name)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), constraints, nil
+}
+
+func (m *memory) Stat(name string) (io.ReadCloser, int64, *Constraints, error) {
+	if strings.Contains(name, "/") || name == "" {
+		return nil, 0, nil, errors.New("invalid file name")
+	}
+	m.RLock()
+	f, ok := m.files[name]
+	m.RUnlock()
+	if !ok {
+		return nil, 0, nil, errors.New("file not stored")
+	}
+	f.constraints.RLock()
+	defer f.constraints.RUnlock()
+	if f.constraints.expired() {
+		return nil, 0, nil, errors.New("file recently expired")
+	}
+	if len(f.constraints.PasswordHash) > 0 {
+		return nil, 0, nil, errors.New("file is password protected")
+	}
+	if f.constraints.Downloads == -1 {
+		return nil, 0, nil, errors.New("download limit exceeded")
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), int64(len(f.data)), f.constraints, nil
+}
+
+func (m *memory) String() string {
+	return "Storage in memory"
+}
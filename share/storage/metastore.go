@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// metaStore persists file Constraints so they survive a process restart.
+type metaStore interface {
+	// Load returns every persisted Constraints, keyed by file name.
+	Load() (map[string]*Constraints, error)
+	// Save persists the constraints for name, overwriting any previous value.
+	Save(name string, c *Constraints) error
+	// Remove deletes any persisted constraints for name.
+	Remove(name string) error
+	// Close releases resources held by the store.
+	Close() error
+}
+
+// sidecarSuffix names the metadata file kept next to each data file. It is
+// deliberately unlikely to collide with a real upload name, unlike a bare
+// ".json" suffix which a file called e.g. "report.json" would also match.
+const sidecarSuffix = ".go-share-meta.json"
+
+// sidecarStore persists each file's Constraints as a "<name>.go-share-meta.json"
+// file next to the data file, in the same directory.
+type sidecarStore struct {
+	dir string
+}
+
+func newSidecarStore(dir string) *sidecarStore {
+	return &sidecarStore{dir: dir}
+}
+
+func (s *sidecarStore) sidecarPath(name string) string {
+	return path.Join(s.dir, name+sidecarSuffix)
+}
+
+func (s *sidecarStore) Load() (map[string]*Constraints, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]*Constraints)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), sidecarSuffix) {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), sidecarSuffix)
+		data, err := ioutil.ReadFile(path.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var c Constraints
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("error parsing metadata for %s: %v", name, err)
+		}
+		m[name] = &c
+	}
+	return m, nil
+}
+
+func (s *sidecarStore) Save(name string, c *Constraints) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.sidecarPath(name), data, 0600)
+}
+
+func (s *sidecarStore) Remove(name string) error {
+	if err := os.Remove(s.sidecarPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *sidecarStore) Close() error { return nil }
+
+// metaBucket is the single bbolt bucket constraints are kept in.
+var metaBucket = []byte("constraints")
+
+// boltStore persists Constraints in a single embedded go.etcd.io/bbolt
+// database, keyed by file name. It is a more compact alternative to
+// sidecarStore when a directory holds many small files.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func newBoltStore(dbPath string) (*boltStore, error) {
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(metaBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (b *boltStore) Load() (map[string]*Constraints, error) {
+	m := make(map[string]*Constraints)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).ForEach(func(k, v []byte) error {
+			var c Constraints
+			if err := json.Unmarshal(v, &c); err != nil {
+				return fmt.Errorf("error parsing metadata for %s: %v", k, err)
+			}
+			m[string(k)] = &c
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (b *boltStore) Save(name string, c *Constraints) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put([]byte(name), data)
+	})
+}
+
+func (b *boltStore) Remove(name string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Delete([]byte(name))
+	})
+}
+
+func (b *boltStore) Close() error { return b.db.Close() }
@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// S3Config configures the S3-compatible backend.
+type S3Config struct {
+	Bucket    string
+	Region    string
+	Endpoint  string // blank for AWS S3 itself, set for MinIO and similar
+	AccessKey string
+	SecretKey string
+	Prefix    string // optional key prefix, e.g. "go-share/"
+	// MetaPath is the path to a local go.etcd.io/bbolt database file used to
+	// persist Constraints across restarts, the same way NewDirectory's
+	// "bbolt" metaBackend does. If empty, Constraints are kept in memory
+	// only and do not survive a restart.
+	MetaPath string
+}
+
+// s3Storage stores files in an S3-compatible object store.
+type s3Storage struct {
+	sync.RWMutex
+	cfg      S3Config
+	client   *s3.S3
+	uploader *s3manager.Uploader
+	files    map[string]*Constraints
+	store    metaStore // nil if cfg.MetaPath is empty
+}
+
+// NewS3 returns a Storage backed by an S3-compatible service.
+func NewS3(cfg S3Config) (Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: bucket is required")
+	}
+	awsCfg := aws.NewConfig().WithRegion(cfg.Region)
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint).WithS3ForcePathStyle(true)
+	}
+	if cfg.AccessKey != "" {
+		awsCfg = awsCfg.WithCredentials(credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, ""))
+	}
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating S3 session: %v", err)
+	}
+	s := &s3Storage{
+		cfg:      cfg,
+		client:   s3.New(sess),
+		uploader: s3manager.NewUploader(sess),
+		files:    make(map[string]*Constraints),
+	}
+	if cfg.MetaPath != "" {
+		store, err := newBoltStore(cfg.MetaPath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening metadata store: %v", err)
+		}
+		s.store = store
+		if err := s.restore(); err != nil {
+			return nil, fmt.Errorf("error restoring metadata: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// restore loads persisted Constraints, drops already-expired files and
+// re-arms time.AfterFunc timers for the ones still pending. Mirrors
+// directory.restore.
+func (s *s3Storage) restore() error {
+	files, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+	s.files = files
+	for name, c := range files {
+		name := name
+		if c.expired() {
+			s.remove(name)
+			continue
+		}
+		if !c.Expire.IsZero() {
+			time.AfterFunc(c.Expire.Sub(time.Now()), func() { s.remove(name) })
+		}
+	}
+	return nil
+}
+
+func (s *s3Storage) key(name string) string {
+	return s.cfg.Prefix + name
+}
+
+func (s *s3Storage) Add(file io.Reader, name string, c Constraints) error {
+	if path.Base(name) != name {
+		return fmt.Errorf("invalid file name %s", name)
+	}
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   file,
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %v", name, err)
+	}
+	s.Lock()
+	s.files[name] = &c
+	s.Unlock()
+	if s.store != nil {
+		if err := s.store.Save(name, &c); err != nil {
+			log.Println("Could not persist metadata for", name, ":", err)
+		}
+	}
+	if !c.Expire.IsZero() {
+		time.AfterFunc(c.Expire.Sub(time.Now()), func() { s.remove(name) })
+	}
+	return nil
+}
+
+// remove handles removal after expiration or when the download limit is reached.
+func (s *s3Storage) remove(name string) {
+	s.Lock()
+	defer s.Unlock()
+	constraints, ok := s.files[name]
+	if !ok {
+		return
+	}
+	constraints.Lock()
+	defer constraints.Unlock()
+	if constraints.Downloads != -1 && !constraints.expired() {
+		return
+	}
+	if constraints.Delete {
+		_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(s.cfg.Bucket),
+			Key:    aws.String(s.key(name)),
+		})
+		if err != nil {
+			log.Println("Could not delete S3 object", name, ":", err)
+		}
+	}
+	delete(s.files, name)
+	if s.store != nil {
+		if err := s.store.Remove(name); err != nil {
+			log.Println("Could not remove metadata for", name, ":", err)
+		}
+	}
+}
+
+// Remove deletes a file outright: both its metadata and its S3 object,
+// regardless of Constraints.Delete, which only governs the automatic
+// cleanup done by remove on expiry or download-limit exhaustion.
+func (s *s3Storage) Remove(name string) {
+	s.Lock()
+	delete(s.files, name)
+	s.Unlock()
+	if s.store != nil {
+		if err := s.store.Remove(name); err != nil {
+			log.Println("Could not remove metadata for", name, ":", err)
+		}
+	}
+	if _, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(name)),
+	}); err != nil {
+		log.Println("Could not delete S3 object", name, ":", err)
+	}
+}
+
+func (s *s3Storage) List() []string {
+	s.RLock()
+	defer s.RUnlock()
+	l := make([]string, 0, len(s.files))
+	for name, c := range s.files {
+		if c.Public {
+			l = append(l, name)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
+
+func (s *s3Storage) Serve(w http.ResponseWriter, r *http.Request, name string) error {
+	if strings.Contains(name, "/") || name == "" {
+		return errors.New("invalid file name")
+	}
+	s.RLock()
+	constraints, ok := s.files[name]
+	s.RUnlock()
+	if !ok {
+		return errors.New("file not stored")
+	}
+	constraints.RLock()
+	if constraints.expired() {
+		constraints.RUnlock()
+		return errors.New("file recently expired")
+	}
+	if !authorized(r, constraints) {
+		constraints.RUnlock()
+		challenge(w, r, name, constraints)
+		return nil
+	}
+	if constraints.Downloads > 0 {
+		constraints.RUnlock()
+		constraints.Lock()
+		switch constraints.Downloads {
+		case 1:
+			constraints.Downloads = -1
+			defer s.remove(name)
+		case -1:
+			constraints.Unlock()
+			return errors.New("download limit exceeded")
+		default:
+			constraints.Downloads--
+			if s.store != nil {
+				if err := s.store.Save(name, constraints); err != nil {
+					log.Println("Could not persist metadata for", name, ":", err)
+				}
+			}
+		}
+		err := s.stream(w, name)
+		constraints.Unlock()
+		return err
+	}
+	err := s.stream(w, name)
+	constraints.RUnlock()
+	return err
+}
+
+// stream proxies the object straight into w, without buffering it to disk.
+func (s *s3Storage) stream(w http.ResponseWriter, name string) error {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching %s from S3: %v", name, err)
+	}
+	defer out.Body.Close()
+	if out.ContentType != nil {
+		w.Header().Set("Content-Type", *out.ContentType)
+	}
+	if out.ContentLength != nil {
+		w.Header().Set("Content-Length", fmt.Sprint(*out.ContentLength))
+	}
+	_, err = io.Copy(w, out.Body)
+	return err
+}
+
+func (s *s3Storage) Open(name string) (io.ReadCloser, int64, *Constraints, error) {
+	if strings.Contains(name, "/") || name == "" {
+		return nil, 0, nil, errors.New("invalid file name")
+	}
+	s.RLock()
+	constraints, ok := s.files[name]
+	s.RUnlock()
+	if !ok {
+		return nil, 0, nil, errors.New("file not stored")
+	}
+	constraints.Lock()
+	if constraints.expired() {
+		constraints.Unlock()
+		return nil, 0, nil, errors.New("file recently expired")
+	}
+	if len(constraints.PasswordHash) > 0 {
+		constraints.Unlock()
+		return nil, 0, nil, errors.New("file is password protected")
+	}
+	remove := false
+	if constraints.Downloads > 0 {
+		switch constraints.Downloads {
+		case 1:
+			constraints.Downloads = -1
+			remove = true
+		case -1:
+			constraints.Unlock()
+			return nil, 0, nil, errors.New("download limit exceeded")
+		default:
+			constraints.Downloads--
+			if s.store != nil {
+				if err := s.store.Save(name, constraints); err != nil {
+					log.Println("Could not persist metadata for", name, ":", err)
+				}
+			}
+		}
+	}
+	constraints.Unlock()
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("error fetching %s from S3: %v", name, err)
+	}
+	var size int64
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	if remove {
+		s.remove(name)
+	}
+	return out.Body, size, constraints, nil
+}
+
+func (s *s3Storage) Stat(name string) (io.ReadCloser, int64, *Constraints, error) {
+	if strings.Contains(name, "/") || name == "" {
+		return nil, 0, nil, errors.New("invalid file name")
+	}
+	s.RLock()
+	constraints, ok := s.files[name]
+	s.RUnlock()
+	if !ok {
+		return nil, 0, nil, errors.New("file not stored")
+	}
+	constraints.RLock()
+	if constraints.expired() {
+		constraints.RUnlock()
+		return nil, 0, nil, errors.New("file recently expired")
+	}
+	if len(constraints.PasswordHash) > 0 {
+		constraints.RUnlock()
+		return nil, 0, nil, errors.New("file is password protected")
+	}
+	if constraints.Downloads == -1 {
+		constraints.RUnlock()
+		return nil, 0, nil, errors.New("download limit exceeded")
+	}
+	constraints.RUnlock()
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("error fetching %s from S3: %v", name, err)
+	}
+	var size int64
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	return out.Body, size, constraints, nil
+}
+
+func (s *s3Storage) String() string {
+	return fmt.Sprint("Storage in S3 bucket ", s.cfg.Bucket)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+)
+
+// shredPasses is the number of random-data overwrite passes performed by
+// shredFile before its final zero pass.
+const shredPasses = 3
+
+// shredChunkSize bounds how much of the file is held in memory at once.
+const shredChunkSize = 1 << 20 // 1 MiB
+
+// shredFile overwrites a file's bytes in place before it is unlinked: a few
+// passes of crypto/rand data, then a final zero pass, fsyncing after each
+// pass so the writes actually reach the storage medium.
+func shredFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	buf := make([]byte, shredChunkSize)
+	for pass := 0; pass < shredPasses; pass++ {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		for remaining := size; remaining > 0; {
+			n := int64(len(buf))
+			if remaining < n {
+				n = remaining
+			}
+			if _, err := rand.Read(buf[:n]); err != nil {
+				return err
+			}
+			if _, err := f.Write(buf[:n]); err != nil {
+				return err
+			}
+			remaining -= n
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	for i := range buf {
+		buf[i] = 0
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	for remaining := size; remaining > 0; {
+		n := int64(len(buf))
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := f.Write(buf[:n]); err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return f.Sync()
+}
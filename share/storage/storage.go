@@ -28,6 +28,17 @@ type Constraints struct {
 	Public bool
 	// Delete specifies whether to delete the file from a server when it becomes unaccessible.
 	Delete bool
+	// PasswordHash is the bcrypt hash of a per-file password, independent of
+	// the server's upload password. Nil means the file has no per-file
+	// password protection.
+	PasswordHash []byte
+	// Token is an unguessable value that grants access to a password-protected
+	// file via a "?t=" query argument or the cookie set after a successful
+	// password check, without prompting again.
+	Token string
+	// MIMEType is the content type detected from the first 512 bytes of the
+	// file at upload time, as reported by http.DetectContentType.
+	MIMEType string
 }
 
 func (c *Constraints) expired() bool {
@@ -47,11 +58,27 @@ type Storage interface {
 	// If a file is not stored or unaccessible due to Constraints, Serve returns
 	// an error.
 	Serve(w http.ResponseWriter, r *http.Request, name string) error
+	// Open returns the raw contents and size of a stored file, applying the
+	// same accessibility checks and download bookkeeping as Serve, but
+	// without any HTTP-specific behavior. It is used by handlers, such as
+	// the archive handler, which combine several files into one response.
+	// Password-protected files cannot be opened this way.
+	Open(name string) (file io.ReadCloser, size int64, c *Constraints, err error)
+	// Stat is like Open but read-only: it applies the same accessibility
+	// checks without touching Constraints.Downloads or triggering removal.
+	// It is used by handlers that only need to inspect or preview a file,
+	// such as the display page and the archive handler's pre-flight check.
+	Stat(name string) (file io.ReadCloser, size int64, c *Constraints, err error)
 }
 
 // NewDirectory returns a Storage which uses file system directory for storing
-// its files. If name is empty, creates a temporary directory.
-func NewDirectory(name string) (Storage, error) {
+// its files. If name is empty, creates a temporary directory. metaBackend
+// selects how Constraints are persisted across restarts: "json" (the
+// default) keeps a "<name>.go-share-meta.json" sidecar next to each file, "bbolt" keeps a
+// single embedded go.etcd.io/bbolt database in the directory instead. If
+// shred is true, a file's bytes are overwritten before being unlinked
+// whenever its Constraints.Delete removal fires.
+func NewDirectory(name, metaBackend string, shred bool) (Storage, error) {
 	if name == "" {
 		var err error
 		name, err = ioutil.TempDir("", "go-share")
@@ -70,10 +97,50 @@ func NewDirectory(name string) (Storage, error) {
 			return nil, fmt.Errorf("%v is not a directory", name)
 		}
 	}
-	return &directory{
+	var store metaStore
+	switch metaBackend {
+	case "", "json":
+		store = newSidecarStore(name)
+	case "bbolt":
+		var err error
+		if store, err = newBoltStore(path.Join(name, ".go-share.db")); err != nil {
+			return nil, fmt.Errorf("error opening metadata store: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown metadata backend %q", metaBackend)
+	}
+	d := &directory{
 		name:  name,
 		files: make(map[string]*Constraints),
-	}, nil
+		store: store,
+		shred: shred,
+	}
+	if err := d.restore(); err != nil {
+		return nil, fmt.Errorf("error restoring metadata: %v", err)
+	}
+	return d, nil
+}
+
+// restore loads persisted Constraints, drops already-expired files and
+// re-arms time.AfterFunc timers for the ones still pending.
+func (d *directory) restore() error {
+	files, err := d.store.Load()
+	if err != nil {
+		return err
+	}
+	d.files = files
+	d.list.dirty = len(files) > 0
+	for name, c := range files {
+		name := name
+		if c.expired() {
+			d.remove(name)
+			continue
+		}
+		if !c.Expire.IsZero() {
+			time.AfterFunc(c.Expire.Sub(time.Now()), func() { d.remove(name) })
+		}
+	}
+	return nil
 }
 
 // list stores a file list.
@@ -89,6 +156,8 @@ type directory struct {
 	sync.RWMutex
 	name  string
 	files map[string]*Constraints
+	store metaStore
+	shred bool
 	list
 }
 
@@ -113,6 +182,9 @@ func (d *directory) Add(file io.Reader, name string, c Constraints) error {
 		return err
 	}
 	d.files[name] = &c
+	if err := d.store.Save(name, &c); err != nil {
+		log.Println("Could not persist metadata for", name, ":", err)
+	}
 	if c.Public {
 		d.list.dirty = true
 	}
@@ -138,7 +210,13 @@ func (d *directory) remove(name string) {
 		return
 	}
 	if constraints.Delete {
-		if err := os.Remove(path.Join(d.name, name)); err != nil {
+		p := path.Join(d.name, name)
+		if d.shred {
+			if err := shredFile(p); err != nil {
+				log.Printf("Could not shred file %s: %s\n", name, err)
+			}
+		}
+		if err := os.Remove(p); err != nil {
 			log.Printf("Could not remove file %s: %s\n", name, err)
 		}
 	}
@@ -146,8 +224,14 @@ func (d *directory) remove(name string) {
 		d.list.dirty = true
 	}
 	delete(d.files, name)
+	if err := d.store.Remove(name); err != nil {
+		log.Println("Could not remove metadata for", name, ":", err)
+	}
 }
 
+// Remove deletes a file outright: both its metadata and its bytes on disk,
+// regardless of Constraints.Delete, which only governs the automatic
+// cleanup done by remove on expiry or download-limit exhaustion.
 func (d *directory) Remove(name string) {
 	d.Lock()
 	defer d.Unlock()
@@ -155,6 +239,18 @@ func (d *directory) Remove(name string) {
 		d.list.dirty = true
 	}
 	delete(d.files, name)
+	if err := d.store.Remove(name); err != nil {
+		log.Println("Could not remove metadata for", name, ":", err)
+	}
+	p := path.Join(d.name, name)
+	if d.shred {
+		if err := shredFile(p); err != nil {
+			log.Printf("Could not shred file %s: %s\n", name, err)
+		}
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		log.Printf("Could not remove file %s: %s\n", name, err)
+	}
 }
 
 func (d *directory) List() []string {
@@ -199,8 +295,14 @@ func (d *directory) Serve(w http.ResponseWriter, r *http.Request, name string) e
 	}
 	constraints.RLock()
 	if constraints.expired() {
+		constraints.RUnlock()
 		return errors.New("File recently expired")
 	}
+	if !authorized(r, constraints) {
+		constraints.RUnlock()
+		challenge(w, r, name, constraints)
+		return nil
+	}
 	if constraints.Downloads > 0 { // have to modify the value
 		constraints.RUnlock()
 		constraints.Lock()
@@ -213,6 +315,9 @@ func (d *directory) Serve(w http.ResponseWriter, r *http.Request, name string) e
 			return errors.New("Download limit exceeded")
 		default:
 			constraints.Downloads--
+			if err := d.store.Save(name, constraints); err != nil {
+				log.Println("Could not persist metadata for", name, ":", err)
+			}
 		}
 		http.ServeFile(w, r, path.Join(d.name, name))
 		constraints.Unlock()
@@ -223,6 +328,109 @@ func (d *directory) Serve(w http.ResponseWriter, r *http.Request, name string) e
 	return nil
 }
 
+func (d *directory) Open(name string) (io.ReadCloser, int64, *Constraints, error) {
+	if strings.Contains(name, "/") || name == "" {
+		return nil, 0, nil, errors.New("invalid file name")
+	}
+	d.RLock()
+	constraints, ok := d.files[name]
+	d.RUnlock()
+	if !ok {
+		return nil, 0, nil, errors.New("file not stored")
+	}
+	constraints.Lock()
+	if constraints.expired() {
+		constraints.Unlock()
+		return nil, 0, nil, errors.New("file recently expired")
+	}
+	if len(constraints.PasswordHash) > 0 {
+		constraints.Unlock()
+		return nil, 0, nil, errors.New("file is password protected")
+	}
+	remove := false
+	if constraints.Downloads > 0 {
+		switch constraints.Downloads {
+		case 1:
+			constraints.Downloads = -1
+			remove = true
+		case -1:
+			constraints.Unlock()
+			return nil, 0, nil, errors.New("download limit exceeded")
+		default:
+			constraints.Downloads--
+			if err := d.store.Save(name, constraints); err != nil {
+				log.Println("Could not persist metadata for", name, ":", err)
+			}
+		}
+	}
+	f, err := os.Open(path.Join(d.name, name))
+	constraints.Unlock()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+	if !remove {
+		return f, fi.Size(), constraints, nil
+	}
+	// With -shred, d.remove overwrites the file's bytes in place: running it
+	// now, while f is still open for the caller to read, would race the
+	// caller's io.Copy. Defer it until the caller is done and closes f.
+	return &removeOnClose{File: f, remove: func() { d.remove(name) }}, fi.Size(), constraints, nil
+}
+
+// removeOnClose wraps an *os.File whose last download has just been served:
+// remove is deferred until Close, after the caller has finished reading.
+type removeOnClose struct {
+	*os.File
+	remove func()
+}
+
+func (f *removeOnClose) Close() error {
+	err := f.File.Close()
+	f.remove()
+	return err
+}
+
+func (d *directory) Stat(name string) (io.ReadCloser, int64, *Constraints, error) {
+	if strings.Contains(name, "/") || name == "" {
+		return nil, 0, nil, errors.New("invalid file name")
+	}
+	d.RLock()
+	constraints, ok := d.files[name]
+	d.RUnlock()
+	if !ok {
+		return nil, 0, nil, errors.New("file not stored")
+	}
+	constraints.RLock()
+	if constraints.expired() {
+		constraints.RUnlock()
+		return nil, 0, nil, errors.New("file recently expired")
+	}
+	if len(constraints.PasswordHash) > 0 {
+		constraints.RUnlock()
+		return nil, 0, nil, errors.New("file is password protected")
+	}
+	if constraints.Downloads == -1 {
+		constraints.RUnlock()
+		return nil, 0, nil, errors.New("download limit exceeded")
+	}
+	constraints.RUnlock()
+	f, err := os.Open(path.Join(d.name, name))
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+	return f, fi.Size(), constraints, nil
+}
+
 func (d *directory) String() string {
 	return fmt.Sprint("Storage in directory ", d.name)
 }